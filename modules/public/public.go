@@ -5,13 +5,18 @@
 package public
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
-	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"os"
 	"path"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"code.gitea.io/gitea/modules/setting"
@@ -27,6 +32,80 @@ type Options struct {
 	ExpiresAfter time.Duration
 	FileSystem   http.FileSystem
 	Prefix       string
+	// PrecompressedEncodings lists, in preference order, the
+	// Accept-Encoding tokens to look for a pre-compressed variant of a
+	// requested file (e.g. "br" for "foo.js.br"). Defaults to
+	// {"br", "gzip"}; set to an empty, non-nil slice to disable.
+	PrecompressedEncodings []string
+	// ImmutablePattern matches request paths that carry a content hash in
+	// their filename (e.g. webpack-style `app.3f2a1c9e.js`). Matching
+	// responses get a long-lived `Cache-Control: immutable` instead of the
+	// usual Expires header, since the path itself changes whenever the
+	// content does.
+	ImmutablePattern *regexp.Regexp
+
+	// etagCache caches the content-hash ETag computed for each served path
+	// so a file already served once is never hashed twice in the life of
+	// the process.
+	etagCache sync.Map
+}
+
+// precompressedExt maps an Accept-Encoding token to the file extension its
+// pre-compressed variant is expected to carry.
+var precompressedExt = map[string]string{
+	"br":   ".br",
+	"gzip": ".gz",
+}
+
+// acceptsEncoding reports whether the client's Accept-Encoding header value
+// accepts enc, per RFC 7231 §5.3.4: an explicit "enc;q=0" (or a "*;q=0"
+// with no explicit entry for enc) means the client has refused it, even
+// though its name otherwise appears in the header.
+func acceptsEncoding(acceptEncoding, enc string) bool {
+	if acceptEncoding == "" {
+		// No Accept-Encoding field at all: any content-coding is acceptable.
+		return true
+	}
+	sawExplicit, explicitOK := false, false
+	sawWildcard, wildcardOK := false, false
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		token, q := part, 1.0
+		if i := strings.IndexByte(part, ';'); i >= 0 {
+			token = part[:i]
+			if v, ok := parseQValue(part[i+1:]); ok {
+				q = v
+			}
+		}
+		token = strings.ToLower(strings.TrimSpace(token))
+		switch token {
+		case enc:
+			sawExplicit, explicitOK = true, q != 0
+		case "*":
+			sawWildcard, wildcardOK = true, q != 0
+		}
+	}
+	if sawExplicit {
+		return explicitOK
+	}
+	if sawWildcard {
+		return wildcardOK
+	}
+	// Header present but this coding wasn't mentioned and there was no
+	// "*" fallback: treat it as not accepted.
+	return false
+}
+
+// parseQValue parses the "q=<value>" parameter of an Accept-Encoding entry.
+func parseQValue(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	if len(s) < 2 || (s[0] != 'q' && s[0] != 'Q') || s[1] != '=' {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(s[2:], 64)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
 }
 
 // KnownPublicEntries list all direct children in the `public` directory
@@ -39,25 +118,24 @@ var KnownPublicEntries = []string{
 }
 
 // Custom implements the macaron static handler for serving custom assets.
+// Custom overrides always come from disk, regardless of whether the bundled
+// `public/` assets are served from disk or from a binary-embedded file
+// system, so that admins can override bundled assets without rebuilding.
 func Custom(opts *Options) func(next http.Handler) http.Handler {
+	if opts.FileSystem == nil {
+		opts.FileSystem = newDiskFileSystem(path.Join(setting.CustomPath, "public"))
+	}
 	return opts.staticHandler(path.Join(setting.CustomPath, "public"))
 }
 
-// staticFileSystem implements http.FileSystem interface.
-type staticFileSystem struct {
-	dir *http.Dir
-}
-
-func newStaticFileSystem(directory string) staticFileSystem {
+// newDiskFileSystem returns an http.FileSystem backed directly by the given
+// directory on disk, resolving it relative to AppWorkPath if it isn't
+// already absolute.
+func newDiskFileSystem(directory string) http.FileSystem {
 	if !filepath.IsAbs(directory) {
 		directory = filepath.Join(setting.AppWorkPath, directory)
 	}
-	dir := http.Dir(directory)
-	return staticFileSystem{&dir}
-}
-
-func (fs staticFileSystem) Open(name string) (http.File, error) {
-	return fs.dir.Open(name)
+	return http.Dir(directory)
 }
 
 // StaticHandler sets up a new middleware for serving static files in the
@@ -83,6 +161,9 @@ func (opts *Options) staticHandler(dir string) func(next http.Handler) http.Hand
 		if opts.FileSystem == nil {
 			opts.FileSystem = newStaticFileSystem(dir)
 		}
+		if opts.PrecompressedEncodings == nil {
+			opts.PrecompressedEncodings = []string{"br", "gzip"}
+		}
 
 		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 			if !opts.handle(w, req, opts) {
@@ -158,23 +239,155 @@ func (opts *Options) handle(w http.ResponseWriter, req *http.Request, opt *Optio
 		log.Println("[Static] Serving " + file)
 	}
 
-	// Add an Expires header to the static content
-	if opt.ExpiresAfter > 0 {
-		w.Header().Set("Expires", time.Now().Add(opt.ExpiresAfter).UTC().Format(http.TimeFormat))
-		tag := GenerateETag(fmt.Sprint(fi.Size()), fi.Name(), fi.ModTime().UTC().Format(http.TimeFormat))
-		w.Header().Set("ETag", tag)
-		if req.Header.Get("If-None-Match") == tag {
-			w.WriteHeader(304)
-			return true
+	// If a pre-compressed variant matching the client's Accept-Encoding
+	// exists, serve that instead of the original file. The variant is
+	// resolved entirely before Expires/ETag are computed, so caching
+	// headers always describe the bytes actually returned.
+	servedName, servedFile, servedInfo := file, f, fi
+	encoding := ""
+	if acceptEncoding := req.Header.Get("Accept-Encoding"); len(opt.PrecompressedEncodings) > 0 && acceptEncoding != "" {
+		for _, enc := range opt.PrecompressedEncodings {
+			ext, ok := precompressedExt[enc]
+			if !ok || !acceptsEncoding(acceptEncoding, enc) {
+				continue
+			}
+			variant := file + ext
+			vf, err := opt.FileSystem.Open(variant)
+			if err != nil {
+				continue
+			}
+			vfi, err := vf.Stat()
+			if err != nil || vfi.IsDir() {
+				vf.Close()
+				continue
+			}
+			defer vf.Close()
+			servedName, servedFile, servedInfo, encoding = variant, vf, vfi, enc
+			break
+		}
+	}
+
+	immutable := opt.ImmutablePattern != nil && opt.ImmutablePattern.MatchString(req.URL.Path)
+
+	// Add caching headers to the static content. Fingerprinted assets get a
+	// long-lived immutable Cache-Control; everything else falls back to the
+	// existing Expires behaviour.
+	if opt.ExpiresAfter > 0 || immutable {
+		tag, err := opt.etag(servedName, servedFile, servedInfo)
+		if err != nil {
+			log.Printf("[Static] %q: failed to compute ETag: %v", servedName, err)
+		} else {
+			w.Header().Set("ETag", tag)
+			if req.Header.Get("If-None-Match") == tag {
+				w.WriteHeader(304)
+				return true
+			}
 		}
+
+		if immutable {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			w.Header().Set("Expires", time.Now().Add(opt.ExpiresAfter).UTC().Format(http.TimeFormat))
+		}
+	}
+
+	// Set the Content-Type from the original (uncompressed) file name so a
+	// served ".br"/".gz" variant still reports the right type, then let
+	// ServeContent know how the body is encoded.
+	if mimeType, ok := mimeTypeByExtension(file); ok {
+		w.Header().Set("Content-Type", mimeType)
+	}
+	// The response for this path can vary by Accept-Encoding whenever
+	// precompressed variants are in play at all, not only on requests that
+	// happened to match one — otherwise a shared cache that stores the
+	// plain response (or a compressed one) for one Accept-Encoding will
+	// replay it to clients that asked for something else.
+	if len(opt.PrecompressedEncodings) > 0 {
+		w.Header().Set("Vary", "Accept-Encoding")
+	}
+	if encoding != "" {
+		w.Header().Set("Content-Encoding", encoding)
 	}
 
-	http.ServeContent(w, req, file, fi.ModTime(), f)
+	http.ServeContent(w, req, servedName, servedInfo.ModTime(), servedFile)
 	return true
 }
 
-// GenerateETag generates an ETag based on size, filename and file modification time
-func GenerateETag(fileSize, fileName, modTime string) string {
-	etag := fileSize + fileName + modTime
-	return base64.StdEncoding.EncodeToString([]byte(etag))
+// cachedETag is what Options.etagCache stores per path: the computed tag,
+// plus the size/mtime it was computed from so a later change to the
+// underlying file (e.g. a Custom override replacing a file on disk) is
+// detected instead of serving a stale tag forever.
+type cachedETag struct {
+	size    int64
+	modTime time.Time
+	tag     string
+}
+
+// etag returns a strong ETag for the file at name, computed from a SHA-256
+// hash of its content. The result is cached for the lifetime of opt, keyed
+// on name plus the file's size and modification time, so a file is only
+// ever hashed again once its size or mtime actually changes - which also
+// keeps disk-backed paths (Custom overrides, the non-bindata build) correct
+// if the underlying file is replaced while the process is running. f is
+// rewound to the start before returning, successfully or not, so it can
+// still be served afterwards.
+func (opt *Options) etag(name string, f http.File, fi os.FileInfo) (string, error) {
+	if cached, ok := opt.etagCache.Load(name); ok {
+		c := cached.(cachedETag)
+		if c.size == fi.Size() && c.modTime.Equal(fi.ModTime()) {
+			return c.tag, nil
+		}
+	}
+
+	h := sha256.New()
+	_, err := io.Copy(h, f)
+	if _, seekErr := f.Seek(0, io.SeekStart); err == nil {
+		err = seekErr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	tag := base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:16])
+	opt.etagCache.Store(name, cachedETag{size: fi.Size(), modTime: fi.ModTime(), tag: tag})
+	return tag, nil
+}
+
+// mimeTypes maps the lowercase extensions of files we actually serve out of
+// `public/` to their Content-Type. Looking extensions up here is both faster
+// and more reliable than falling back to mime.TypeByExtension, which may
+// consult the OS mime database and is notoriously wrong for `.js`/`.css` on
+// Windows.
+var mimeTypes = map[string]string{
+	".avif":  "image/avif",
+	".css":   "text/css; charset=utf-8",
+	".eot":   "application/vnd.ms-fontobject",
+	".gif":   "image/gif",
+	".htm":   "text/html; charset=utf-8",
+	".html":  "text/html; charset=utf-8",
+	".ico":   "image/x-icon",
+	".jpeg":  "image/jpeg",
+	".jpg":   "image/jpeg",
+	".js":    "text/javascript; charset=utf-8",
+	".json":  "application/json; charset=utf-8",
+	".map":   "application/json; charset=utf-8",
+	".mjs":   "text/javascript; charset=utf-8",
+	".pdf":   "application/pdf",
+	".png":   "image/png",
+	".svg":   "image/svg+xml",
+	".ttf":   "font/ttf",
+	".wasm":  "application/wasm",
+	".webp":  "image/webp",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".xml":   "text/xml; charset=utf-8",
+}
+
+// mimeTypeByExtension returns the Content-Type for the given file extension
+// (as returned by path.Ext, including the leading dot), matched
+// case-insensitively. The bool result reports whether name was recognised.
+func mimeTypeByExtension(name string) (string, bool) {
+	ext := strings.ToLower(path.Ext(name))
+	mimeType, ok := mimeTypes[ext]
+	return mimeType, ok
 }
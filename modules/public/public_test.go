@@ -0,0 +1,145 @@
+// Copyright 2020 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package public
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAcceptsEncoding(t *testing.T) {
+	cases := []struct {
+		name           string
+		acceptEncoding string
+		enc            string
+		want           bool
+	}{
+		{"no header accepts anything", "", "br", true},
+		{"explicit accept", "gzip, br", "br", true},
+		{"explicit refuse elsewhere", "gzip, br", "gzip", true},
+		{"not mentioned, no wildcard", "gzip", "br", false},
+		{"explicit q=0 refuses", "br;q=0, gzip", "br", false},
+		{"explicit q=0.0 refuses", "br;q=0.0, gzip", "br", false},
+		{"wildcard accepts", "*", "br", true},
+		{"wildcard q=0 refuses", "*;q=0", "br", false},
+		{"explicit entry wins over wildcard", "*;q=0, br;q=1", "br", true},
+		{"wildcard wins when not explicitly mentioned", "*;q=0, gzip", "br", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := acceptsEncoding(c.acceptEncoding, c.enc); got != c.want {
+				t.Errorf("acceptsEncoding(%q, %q) = %v, want %v", c.acceptEncoding, c.enc, got, c.want)
+			}
+		})
+	}
+}
+
+func TestParseQValue(t *testing.T) {
+	cases := []struct {
+		s      string
+		want   float64
+		wantOK bool
+	}{
+		{"q=0", 0, true},
+		{"q=1", 1, true},
+		{"q=0.5", 0.5, true},
+		{" q=0.8 ", 0.8, true},
+		{"Q=0.3", 0.3, true},
+		{"charset=utf-8", 0, false},
+		{"", 0, false},
+	}
+
+	for _, c := range cases {
+		got, ok := parseQValue(c.s)
+		if ok != c.wantOK || (ok && got != c.want) {
+			t.Errorf("parseQValue(%q) = (%v, %v), want (%v, %v)", c.s, got, ok, c.want, c.wantOK)
+		}
+	}
+}
+
+// fakeFileInfo is a minimal os.FileInfo for exercising etag's cache keying.
+type fakeFileInfo struct {
+	size    int64
+	modTime time.Time
+}
+
+func (fi fakeFileInfo) Name() string       { return "fake" }
+func (fi fakeFileInfo) Size() int64        { return fi.size }
+func (fi fakeFileInfo) Mode() os.FileMode  { return 0 }
+func (fi fakeFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi fakeFileInfo) IsDir() bool        { return false }
+func (fi fakeFileInfo) Sys() interface{}   { return nil }
+
+// fakeFile implements http.File over an in-memory string, so tests can feed
+// etag() content without touching disk.
+type fakeFile struct {
+	*strings.Reader
+}
+
+func newFakeFile(content string) *fakeFile {
+	return &fakeFile{strings.NewReader(content)}
+}
+
+func (*fakeFile) Close() error                             { return nil }
+func (*fakeFile) Readdir(count int) ([]os.FileInfo, error) { return nil, nil }
+func (*fakeFile) Stat() (os.FileInfo, error)               { return nil, nil }
+
+func TestOptionsETag(t *testing.T) {
+	var opt Options
+	modTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	fi := fakeFileInfo{size: 5, modTime: modTime}
+	tag1, err := opt.etag("/app.js", newFakeFile("hello"), fi)
+	if err != nil {
+		t.Fatalf("etag() error = %v", err)
+	}
+	if tag1 == "" {
+		t.Fatal("etag() returned an empty tag")
+	}
+
+	// Same path, same size/mtime: must hit the cache and return the same
+	// tag even though the content handed in this time differs - the cache
+	// entry, not a re-read, is what's authoritative once size/mtime match.
+	tag2, err := opt.etag("/app.js", newFakeFile("world"), fi)
+	if err != nil {
+		t.Fatalf("etag() error = %v", err)
+	}
+	if tag2 != tag1 {
+		t.Fatalf("etag() = %q on cache hit, want cached %q", tag2, tag1)
+	}
+
+	// Same path, but the file's size changed: must recompute, not serve
+	// the stale cached tag.
+	fiChangedSize := fakeFileInfo{size: 6, modTime: modTime}
+	tag3, err := opt.etag("/app.js", newFakeFile("world!"), fiChangedSize)
+	if err != nil {
+		t.Fatalf("etag() error = %v", err)
+	}
+	if tag3 == tag1 {
+		t.Fatal("etag() returned the stale cached tag after the file's size changed")
+	}
+
+	// Same path, size changed back but mtime bumped: must also recompute.
+	fiChangedTime := fakeFileInfo{size: 5, modTime: modTime.Add(time.Second)}
+	tag4, err := opt.etag("/app.js", newFakeFile("howdy"), fiChangedTime)
+	if err != nil {
+		t.Fatalf("etag() error = %v", err)
+	}
+	if tag4 == tag1 || tag4 == tag3 {
+		t.Fatal("etag() returned a stale cached tag after the file's mtime changed")
+	}
+
+	// Hashing is deterministic: identical content yields identical tags.
+	tag5, err := opt.etag("/other.js", newFakeFile("hello"), fakeFileInfo{size: 5, modTime: modTime})
+	if err != nil {
+		t.Fatalf("etag() error = %v", err)
+	}
+	if tag5 != tag1 {
+		t.Fatalf("etag() = %q for identical content, want %q", tag5, tag1)
+	}
+}
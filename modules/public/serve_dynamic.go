@@ -0,0 +1,20 @@
+// Copyright 2020 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build !bindata
+// +build !bindata
+
+package public
+
+import (
+	"net/http"
+)
+
+// newStaticFileSystem creates a new static file system that serves the
+// bundled `public` assets straight off disk. This is the default file
+// system used when Gitea is built without the `bindata` build tag, and
+// requires the `public/` directory to be present alongside the binary.
+func newStaticFileSystem(directory string) http.FileSystem {
+	return newDiskFileSystem(directory)
+}
@@ -0,0 +1,40 @@
+// Copyright 2020 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build bindata
+// +build bindata
+
+package public
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// bindata holds the contents of the `public/` directory, copied into
+// modules/public/bindata by `generate-bindata.sh` (run via `go generate
+// ./modules/public`, aliased as `make generate-bindata`) before a
+// `bindata` build so it can be embedded into the binary. The `all:`
+// prefix makes sure the directory is still embeddable when it only holds
+// the repository's dot-free placeholder file, and keeps any dotfiles
+// that end up in a real public/ tree (e.g. `.well-known`) once
+// generated.
+//
+//go:generate ./generate-bindata.sh
+//go:embed all:bindata
+var bindata embed.FS
+
+// newStaticFileSystem creates a new static file system serving the public
+// assets bundled into the binary at build time, for single-binary
+// distributions that need no `public/` directory present on disk. The
+// `directory` argument is ignored; the embedded tree always represents
+// `public/` as it existed at build time.
+func newStaticFileSystem(directory string) http.FileSystem {
+	sub, err := fs.Sub(bindata, "bindata")
+	if err != nil {
+		panic(err)
+	}
+	return http.FS(sub)
+}